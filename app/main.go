@@ -44,7 +44,10 @@ func main() {
 		sig := <-interrupts
 		log.Info().Msgf("Signal intercepted %v", sig)
 
-		if err := kafkaServer.Shutdown(); err != nil {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer shutdownCancel()
+
+		if err := kafkaServer.Shutdown(shutdownCtx); err != nil {
 			log.Fatal().Err(err).Msg("Error closing kafka server")
 		}
 
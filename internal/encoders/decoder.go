@@ -0,0 +1,99 @@
+package encoders
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// decoder reads Kafka primitive types out of an in-memory buffer.
+type decoder struct {
+	buf []byte
+	pos int
+}
+
+func newDecoder(buf []byte) *decoder {
+	return &decoder{buf: buf}
+}
+
+// remaining returns the unread tail of the buffer, e.g. the API-specific
+// body following a request header.
+func (d *decoder) remaining() []byte {
+	return d.buf[d.pos:]
+}
+
+func (d *decoder) readInt16() (int16, error) {
+	if len(d.buf)-d.pos < 2 {
+		return 0, errors.New("unexpected end of buffer reading int16")
+	}
+	v := int16(binary.BigEndian.Uint16(d.buf[d.pos:]))
+	d.pos += 2
+	return v, nil
+}
+
+func (d *decoder) readInt32() (int32, error) {
+	if len(d.buf)-d.pos < 4 {
+		return 0, errors.New("unexpected end of buffer reading int32")
+	}
+	v := int32(binary.BigEndian.Uint32(d.buf[d.pos:]))
+	d.pos += 4
+	return v, nil
+}
+
+// readNullableString reads a Kafka NULLABLE_STRING: an int16 length followed
+// by that many bytes, or a length of -1 for a nil string.
+func (d *decoder) readNullableString() (*string, error) {
+	n, err := d.readInt16()
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, nil
+	}
+	if len(d.buf)-d.pos < int(n) {
+		return nil, errors.New("unexpected end of buffer reading string")
+	}
+	s := string(d.buf[d.pos : d.pos+int(n)])
+	d.pos += int(n)
+	return &s, nil
+}
+
+// readUnsignedVarint reads a Kafka UNSIGNED_VARINT.
+func (d *decoder) readUnsignedVarint() (uint32, error) {
+	var v uint32
+	for shift := uint(0); ; shift += 7 {
+		if d.pos >= len(d.buf) {
+			return 0, errors.New("unexpected end of buffer reading varint")
+		}
+		b := d.buf[d.pos]
+		d.pos++
+		v |= uint32(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+}
+
+// readTaggedFields consumes the tagged fields section of a flexible-version
+// message: a count followed by that many (tag, size, data) tuples. mini-kafka
+// doesn't define any tags yet, so they're skipped rather than interpreted.
+func (d *decoder) readTaggedFields() error {
+	count, err := d.readUnsignedVarint()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < count; i++ {
+		if _, err := d.readUnsignedVarint(); err != nil { // tag
+			return err
+		}
+		size, err := d.readUnsignedVarint()
+		if err != nil {
+			return err
+		}
+		if len(d.buf)-d.pos < int(size) {
+			return errors.New("unexpected end of buffer reading tagged field")
+		}
+		d.pos += int(size)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package encoders
+
+import "encoding/binary"
+
+// Encoder writes Kafka primitive types into an in-memory buffer, for
+// building the API-specific body of a [github.com/xuanswe/mini-kafka/internal/models.Response].
+type Encoder struct {
+	buf []byte
+}
+
+func NewEncoder() *Encoder {
+	return &Encoder{}
+}
+
+// Bytes returns the encoded buffer built so far.
+func (e *Encoder) Bytes() []byte {
+	return e.buf
+}
+
+func (e *Encoder) WriteInt16(v int16) {
+	e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v))
+}
+
+func (e *Encoder) WriteInt32(v int32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v))
+}
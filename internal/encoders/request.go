@@ -0,0 +1,80 @@
+package encoders
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/xuanswe/mini-kafka/internal/models"
+	"github.com/xuanswe/mini-kafka/internal/support"
+)
+
+// DefaultMaxRequestSize is used when ReadRequest is given a maxSize <= 0. It
+// matches Kafka's own default for socket.request.max.bytes.
+const DefaultMaxRequestSize = 100 * 1024 * 1024 // 100 MiB
+
+// ReadRequest reads a single length-prefixed Kafka request frame off reader:
+// an int32 size followed by a request header v2 (api_key, api_version,
+// correlation_id, a nullable client_id, and tagged fields) and the
+// still-encoded, API-specific body.
+//
+// A claimed size larger than maxSize (or <= 0 to use DefaultMaxRequestSize)
+// is rejected before the frame body is allocated or read, so a peer can't
+// force a large allocation just by sending a 4-byte length prefix.
+func ReadRequest(reader io.Reader, maxSize int32) (*models.Request, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxRequestSize
+	}
+
+	br := support.EnsureBufferedReader(reader)
+
+	var size int32
+	if err := binary.Read(br, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < 0 {
+		return nil, errors.Errorf("invalid request size %d", size)
+	}
+	if size > maxSize {
+		return nil, errors.Errorf("request size %d exceeds max request size %d", size, maxSize)
+	}
+
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(br, frame); err != nil {
+		return nil, err
+	}
+
+	return decodeRequest(frame)
+}
+
+func decodeRequest(frame []byte) (*models.Request, error) {
+	d := newDecoder(frame)
+
+	apiKey, err := d.readInt16()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading api_key")
+	}
+	apiVersion, err := d.readInt16()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading api_version")
+	}
+	correlationId, err := d.readInt32()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading correlation_id")
+	}
+	clientId, err := d.readNullableString()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading client_id")
+	}
+	if err := d.readTaggedFields(); err != nil {
+		return nil, errors.Wrap(err, "reading request header tagged fields")
+	}
+
+	return &models.Request{
+		ApiKey:        models.ApiKey(apiKey),
+		ApiVersion:    apiVersion,
+		CorrelationId: correlationId,
+		ClientId:      clientId,
+		Body:          d.remaining(),
+	}, nil
+}
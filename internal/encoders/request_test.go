@@ -0,0 +1,122 @@
+package encoders
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+// buildRequestHeader encodes a request header v2 (without the leading
+// length prefix): api_key, api_version, correlation_id, a nullable
+// client_id, and empty tagged fields.
+func buildRequestHeader(apiKey, apiVersion int16, correlationId int32, clientId *string) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, apiKey)
+	_ = binary.Write(&buf, binary.BigEndian, apiVersion)
+	_ = binary.Write(&buf, binary.BigEndian, correlationId)
+	if clientId == nil {
+		_ = binary.Write(&buf, binary.BigEndian, int16(-1))
+	} else {
+		_ = binary.Write(&buf, binary.BigEndian, int16(len(*clientId)))
+		buf.WriteString(*clientId)
+	}
+	buf.WriteByte(0) // tagged field count = 0
+	return buf.Bytes()
+}
+
+// prefixSize prepends frame with its int32 length prefix, as ReadRequest
+// expects on the wire.
+func prefixSize(frame []byte) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, int32(len(frame)))
+	buf.Write(frame)
+	return buf.Bytes()
+}
+
+func TestReadRequest_ValidApiVersionsRequest(t *testing.T) {
+	clientId := "kafka-broker-api-versions"
+	body := []byte{0x01, 0x02}
+	frame := append(buildRequestHeader(18, 0, 42, &clientId), body...)
+
+	req, err := ReadRequest(bytes.NewReader(prefixSize(frame)), 0)
+	if err != nil {
+		t.Fatalf("ReadRequest: %v", err)
+	}
+	if req.ApiKey != 18 || req.ApiVersion != 0 || req.CorrelationId != 42 {
+		t.Fatalf("unexpected header fields: %+v", req)
+	}
+	if req.ClientId == nil || *req.ClientId != clientId {
+		t.Fatalf("unexpected client id: %v", req.ClientId)
+	}
+	if !bytes.Equal(req.Body, body) {
+		t.Fatalf("unexpected body: %v", req.Body)
+	}
+}
+
+func TestReadRequest_NilClientId(t *testing.T) {
+	frame := buildRequestHeader(18, 0, 1, nil)
+
+	req, err := ReadRequest(bytes.NewReader(prefixSize(frame)), 0)
+	if err != nil {
+		t.Fatalf("ReadRequest: %v", err)
+	}
+	if req.ClientId != nil {
+		t.Fatalf("expected nil client id, got %v", *req.ClientId)
+	}
+}
+
+func TestReadRequest_NegativeSize(t *testing.T) {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, int32(-1))
+
+	if _, err := ReadRequest(&buf, 0); err == nil {
+		t.Fatal("expected error for negative size")
+	}
+}
+
+func TestReadRequest_SizeExceedsMax(t *testing.T) {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, int32(1<<20))
+
+	if _, err := ReadRequest(&buf, 1024); err == nil {
+		t.Fatal("expected error for request size exceeding maxSize")
+	}
+}
+
+func TestReadRequest_TruncatedFrame(t *testing.T) {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, int32(10))
+	buf.Write([]byte{1, 2, 3}) // fewer than the claimed 10 bytes
+
+	_, err := ReadRequest(&buf, 0)
+	if err == nil {
+		t.Fatal("expected error for truncated frame")
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		t.Fatalf("expected an EOF-flavored error, got %v", err)
+	}
+}
+
+func TestDecodeRequest_TruncatedHeader(t *testing.T) {
+	// 3 bytes isn't even enough for api_key+api_version (4 bytes).
+	if _, err := decodeRequest([]byte{0, 1, 0}); err == nil {
+		t.Fatal("expected error decoding a truncated header")
+	}
+}
+
+func TestDecoder_ReadTaggedFields_MalformedVarint(t *testing.T) {
+	// A varint whose continuation bit is always set never terminates.
+	d := newDecoder([]byte{0x80, 0x80, 0x80})
+	if err := d.readTaggedFields(); err == nil {
+		t.Fatal("expected error for a malformed varint")
+	}
+}
+
+func TestDecoder_ReadNullableString_TruncatedContent(t *testing.T) {
+	d := newDecoder([]byte{0, 5, 'h', 'i'}) // claims 5 bytes, has 2
+	if _, err := d.readNullableString(); err == nil {
+		t.Fatal("expected error for a truncated string")
+	}
+}
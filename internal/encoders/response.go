@@ -0,0 +1,26 @@
+package encoders
+
+import (
+	"encoding/binary"
+
+	"github.com/xuanswe/mini-kafka/internal/models"
+)
+
+// EncodeResponse serializes resp into a length-prefixed Kafka response
+// frame: an int32 size followed by the response header and resp.Body.
+//
+// Only response header v0 (just the correlation_id) is produced for now.
+// ApiVersions in particular must always reply with header v0, even for
+// flexible API versions, since the client can't know whether the broker
+// supports flexible responses until after it has parsed the ApiVersions
+// response.
+func EncodeResponse(resp *models.Response) ([]byte, error) {
+	size := 4 + len(resp.Body) // correlation_id + body
+
+	frame := make([]byte, 0, 4+size)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(size))
+	frame = binary.BigEndian.AppendUint32(frame, uint32(resp.CorrelationId))
+	frame = append(frame, resp.Body...)
+
+	return frame, nil
+}
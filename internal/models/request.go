@@ -0,0 +1,39 @@
+package models
+
+import "crypto/tls"
+
+// ApiKey identifies a Kafka request type, as listed in the Kafka protocol
+// guide (https://kafka.apache.org/protocol.html#protocol_api_keys).
+type ApiKey int16
+
+const (
+	ApiKeyProduce     ApiKey = 0
+	ApiKeyFetch       ApiKey = 1
+	ApiKeyMetadata    ApiKey = 3
+	ApiKeyApiVersions ApiKey = 18
+)
+
+// Request is a single Kafka request, decoded off the wire by
+// [github.com/xuanswe/mini-kafka/internal/encoders.ReadRequest]. Body is
+// left encoded so that each API's handler can decode it with the schema for
+// its own ApiVersion.
+type Request struct {
+	// ApiKey identifies which Kafka API this request is for.
+	ApiKey ApiKey
+	// ApiVersion is the version of ApiKey the client is speaking.
+	ApiVersion int16
+	// CorrelationId is echoed back unchanged in the response header.
+	CorrelationId int32
+	// ClientId is the client-supplied identifier, or nil if absent.
+	ClientId *string
+	// Body is the still-encoded, API-specific portion of the request.
+	Body []byte
+
+	// RemoteAddr is the address of the client that sent the request.
+	RemoteAddr string
+	// TLSState is the TLS connection state of the client connection, or nil
+	// if the connection isn't using TLS. When mTLS is enforced via
+	// ServerConfig.TLSConfig.ClientAuth, handlers can use
+	// TLSState.PeerCertificates to authenticate the client.
+	TLSState *tls.ConnectionState
+}
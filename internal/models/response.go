@@ -0,0 +1,10 @@
+package models
+
+// Response is a single Kafka response, ready to be framed onto the wire by
+// [github.com/xuanswe/mini-kafka/internal/encoders.EncodeResponse].
+type Response struct {
+	// CorrelationId must equal the CorrelationId of the Request it answers.
+	CorrelationId int32
+	// Body is the already-encoded, API-specific portion of the response.
+	Body []byte
+}
@@ -0,0 +1,22 @@
+package support
+
+import (
+	"bufio"
+	"io"
+)
+
+// EnsureBufferedReader wraps r in a *bufio.Reader, unless it already is one.
+func EnsureBufferedReader(r io.Reader) *bufio.Reader {
+	if br, ok := r.(*bufio.Reader); ok {
+		return br
+	}
+	return bufio.NewReader(r)
+}
+
+// EnsureBufferedWriter wraps w in a *bufio.Writer, unless it already is one.
+func EnsureBufferedWriter(w io.Writer) *bufio.Writer {
+	if bw, ok := w.(*bufio.Writer); ok {
+		return bw
+	}
+	return bufio.NewWriter(w)
+}
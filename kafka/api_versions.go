@@ -0,0 +1,38 @@
+package kafka
+
+import (
+	"github.com/xuanswe/mini-kafka/internal/encoders"
+	"github.com/xuanswe/mini-kafka/internal/models"
+)
+
+// supportedApis is the hardcoded set of (api_key, min_version, max_version)
+// triples mini-kafka currently understands. It's returned verbatim in every
+// ApiVersions response so that Kafka clients (and kafka-broker-api-versions.sh)
+// can complete the initial handshake.
+//
+// TODO: grow this list as Metadata, Produce, and Fetch are implemented.
+var supportedApis = []struct {
+	apiKey     models.ApiKey
+	minVersion int16
+	maxVersion int16
+}{
+	{models.ApiKeyApiVersions, 0, 0},
+}
+
+// handleApiVersions answers an ApiVersions (key 18) request with mini-kafka's
+// hardcoded supported-versions array, using response header/body version 0.
+func handleApiVersions(request *models.Request) (*models.Response, error) {
+	e := encoders.NewEncoder()
+	e.WriteInt16(0) // error_code
+	e.WriteInt32(int32(len(supportedApis)))
+	for _, api := range supportedApis {
+		e.WriteInt16(int16(api.apiKey))
+		e.WriteInt16(api.minVersion)
+		e.WriteInt16(api.maxVersion)
+	}
+
+	return &models.Response{
+		CorrelationId: request.CorrelationId,
+		Body:          e.Bytes(),
+	}, nil
+}
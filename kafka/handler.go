@@ -0,0 +1,22 @@
+package kafka
+
+import (
+	"context"
+
+	"github.com/xuanswe/mini-kafka/internal/models"
+)
+
+// Handler processes a single decoded Kafka request and produces its
+// response. Set ServerConfig.Handler to plug in request handling; if unset,
+// the server falls back to its built-in per-API handlers (see
+// defaultHandler).
+type Handler interface {
+	Handle(ctx context.Context, req *models.Request) (*models.Response, error)
+}
+
+// HandlerFunc adapts a plain function to a Handler.
+type HandlerFunc func(ctx context.Context, req *models.Request) (*models.Response, error)
+
+func (f HandlerFunc) Handle(ctx context.Context, req *models.Request) (*models.Response, error) {
+	return f(ctx, req)
+}
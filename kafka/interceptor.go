@@ -0,0 +1,31 @@
+package kafka
+
+import (
+	"context"
+
+	"github.com/xuanswe/mini-kafka/internal/models"
+)
+
+// Next is the remaining work in an interceptor chain: either the next
+// Interceptor or, for the innermost call, the Handler itself.
+type Next func(ctx context.Context, req *models.Request) (*models.Response, error)
+
+// Interceptor wraps a Handler call, e.g. to add logging, panic recovery,
+// timeouts, or metrics without the Handler itself needing to know about
+// them. Set ServerConfig.Interceptors to install a chain; they run in the
+// order given, interceptors[0] outermost.
+type Interceptor func(ctx context.Context, req *models.Request, next Next) (*models.Response, error)
+
+// chainInterceptors composes interceptors around handler into a single
+// Next, with interceptors[0] as the outermost call.
+func chainInterceptors(interceptors []Interceptor, handler Handler) Next {
+	next := handler.Handle
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor := interceptors[i]
+		inner := next
+		next = func(ctx context.Context, req *models.Request) (*models.Response, error) {
+			return interceptor(ctx, req, inner)
+		}
+	}
+	return next
+}
@@ -0,0 +1,58 @@
+package kafka
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/xuanswe/mini-kafka/internal/models"
+)
+
+// LoggingInterceptor replaces the server's old ad-hoc log.Debug calls with
+// structured, per-request logging: ApiKey, ApiVersion, CorrelationId,
+// RemoteAddr, how long the request took, and the error if any.
+func LoggingInterceptor(ctx context.Context, req *models.Request, next Next) (*models.Response, error) {
+	start := time.Now()
+
+	resp, err := next(ctx, req)
+
+	event := log.Debug()
+	if err != nil {
+		event = log.Error().Err(err)
+	}
+	event.
+		Int16("apiKey", int16(req.ApiKey)).
+		Int16("apiVersion", req.ApiVersion).
+		Int32("correlationId", req.CorrelationId).
+		Str("remoteAddr", req.RemoteAddr).
+		Dur("duration", time.Since(start)).
+		Msg("Processed request")
+
+	return resp, err
+}
+
+// RecoveryInterceptor turns a panic in a downstream Handler or Interceptor
+// into an error, so a single bad request can't crash its connection's
+// goroutine.
+func RecoveryInterceptor(ctx context.Context, req *models.Request, next Next) (resp *models.Response, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().Interface("panic", r).Msg("Recovered from panic handling request")
+			err = errors.Errorf("panic handling request: %v", r)
+		}
+	}()
+
+	return next(ctx, req)
+}
+
+// TimeoutInterceptor gives each request a context that is cancelled after
+// timeout. A handler that doesn't watch ctx is not interrupted.
+func TimeoutInterceptor(timeout time.Duration) Interceptor {
+	return func(ctx context.Context, req *models.Request, next Next) (*models.Response, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		return next(ctx, req)
+	}
+}
@@ -0,0 +1,35 @@
+package kafka
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/xuanswe/mini-kafka/internal/models"
+)
+
+// Metrics tracks simple request counters: total requests seen, requests
+// that errored, and requests currently in flight. Install it via
+// Metrics.Intercept and read the counters at any time, e.g. to serve a
+// /metrics endpoint.
+type Metrics struct {
+	requests atomic.Int64
+	errors   atomic.Int64
+	inFlight atomic.Int64
+}
+
+func (m *Metrics) Requests() int64 { return m.requests.Load() }
+func (m *Metrics) Errors() int64   { return m.errors.Load() }
+func (m *Metrics) InFlight() int64 { return m.inFlight.Load() }
+
+// Intercept is an Interceptor that updates m around every request.
+func (m *Metrics) Intercept(ctx context.Context, req *models.Request, next Next) (*models.Response, error) {
+	m.requests.Add(1)
+	m.inFlight.Add(1)
+	defer m.inFlight.Add(-1)
+
+	resp, err := next(ctx, req)
+	if err != nil {
+		m.errors.Add(1)
+	}
+	return resp, err
+}
@@ -0,0 +1,98 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/xuanswe/mini-kafka/internal/encoders"
+	"github.com/xuanswe/mini-kafka/internal/models"
+)
+
+// DefaultMaxInFlightRequestsPerConnection is used when
+// ServerConfig.MaxInFlightRequestsPerConnection is unset.
+const DefaultMaxInFlightRequestsPerConnection = 16
+
+// pipelineRequests handles up to maxInFlight requests from requestChan
+// concurrently, but returns their encoded responses on the result channel
+// strictly in the order the requests arrived on the connection, as Kafka's
+// per-connection ordering guarantee requires. A request that's slow to
+// handle holds up the responses behind it, but not the ones ahead of it,
+// and handling itself proceeds for requests behind it up to maxInFlight.
+func pipelineRequests(ctx context.Context, c *conn, requestChan <-chan *models.Request, handle Next, maxInFlight int) <-chan []byte {
+	// slots preserves request-arrival order: the writer below reads them
+	// out in the order they were enqueued, blocking on each until its
+	// response is ready. Its capacity plus the one slot the writer is
+	// waiting on is the in-flight window: once it's full, enqueuing a new
+	// slot blocks, which blocks reading the next request off requestChan.
+	slots := make(chan chan []byte, maxInFlight-1)
+	responseChan := make(chan []byte)
+
+	go func() {
+		defer close(slots)
+
+		var wg sync.WaitGroup
+		defer wg.Wait()
+
+		for request := range requestChan {
+			slot := make(chan []byte, 1)
+
+			select {
+			case <-ctx.Done():
+				return
+			case slots <- slot:
+			}
+
+			wg.Add(1)
+			go func(req *models.Request, slot chan<- []byte) {
+				defer wg.Done()
+				slot <- processRequest(ctx, c, req, handle)
+			}(request, slot)
+		}
+	}()
+
+	go func() {
+		defer close(responseChan)
+
+		for slot := range slots {
+			select {
+			case <-ctx.Done():
+				return
+			case bytes := <-slot:
+				if bytes == nil {
+					continue
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case responseChan <- bytes:
+				}
+			}
+		}
+	}()
+
+	return responseChan
+}
+
+// processRequest runs handle for a single request and encodes its response,
+// or returns nil if the request failed. c is marked active for the duration
+// of the call, so Shutdown won't close the connection out from under an
+// in-flight request.
+func processRequest(ctx context.Context, c *conn, req *models.Request, handle Next) []byte {
+	c.beginRequest()
+	defer c.endRequest()
+
+	response, err := handle(ctx, req)
+	if err != nil {
+		log.Error().Err(err).Msg("Error processing request")
+		return nil
+	}
+
+	bytes, err := encoders.EncodeResponse(response)
+	if err != nil {
+		log.Error().Err(err).Msg("Error encoding response")
+		return nil
+	}
+	return bytes
+}
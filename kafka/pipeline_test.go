@@ -0,0 +1,58 @@
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/xuanswe/mini-kafka/internal/models"
+)
+
+func TestPipelineRequests_ResponsesPreserveRequestOrder(t *testing.T) {
+	const requestCount = 50
+
+	requestChan := make(chan *models.Request)
+	go func() {
+		defer close(requestChan)
+		for i := 0; i < requestCount; i++ {
+			requestChan <- &models.Request{CorrelationId: int32(i)}
+		}
+	}()
+
+	// Each request sleeps for a random duration so that, without
+	// per-connection ordering, a fast later request could overtake a
+	// slower earlier one.
+	handle := HandlerFunc(func(ctx context.Context, req *models.Request) (*models.Response, error) {
+		time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+		return &models.Response{CorrelationId: req.CorrelationId}, nil
+	}).Handle
+
+	c := &conn{state: StateNew}
+	responseChan := pipelineRequests(context.Background(), c, requestChan, handle, 8)
+
+	var got []int32
+	for bytes := range responseChan {
+		got = append(got, correlationIdOf(t, bytes))
+	}
+
+	if len(got) != requestCount {
+		t.Fatalf("got %d responses, want %d", len(got), requestCount)
+	}
+	for i, correlationId := range got {
+		if correlationId != int32(i) {
+			t.Fatalf("response %d has correlation_id %d, want %d", i, correlationId, i)
+		}
+	}
+}
+
+// correlationIdOf extracts the correlation_id from an encoded response
+// frame: a 4-byte size prefix, then the 4-byte correlation_id.
+func correlationIdOf(t *testing.T, frame []byte) int32 {
+	t.Helper()
+	if len(frame) < 8 {
+		t.Fatalf("response frame too short: %d bytes", len(frame))
+	}
+	return int32(binary.BigEndian.Uint32(frame[4:8]))
+}
@@ -1,11 +1,34 @@
 package kafka
 
 import (
-	"github.com/rs/zerolog/log"
+	"context"
+
+	"github.com/pkg/errors"
 	"github.com/xuanswe/mini-kafka/internal/models"
 )
 
-func handleRequest(request *models.Request) ([]byte, error) {
-	log.Debug().Msgf("Processing request: %v", request)
-	return []byte("Hello " + request.Data + "!"), nil
-}
+// defaultHandler dispatches requests to mini-kafka's built-in per-API
+// handlers, keyed on ApiKey. It's used when ServerConfig.Handler is unset.
+var defaultHandler Handler = HandlerFunc(func(ctx context.Context, request *models.Request) (*models.Response, error) {
+	var response *models.Response
+	var err error
+
+	switch request.ApiKey {
+	case models.ApiKeyApiVersions:
+		response, err = handleApiVersions(request)
+	default:
+		return nil, errors.Errorf("unsupported api key %d", request.ApiKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if response.CorrelationId != request.CorrelationId {
+		return nil, errors.Errorf(
+			"handler for api key %d returned correlation_id %d, want %d",
+			request.ApiKey, response.CorrelationId, request.CorrelationId,
+		)
+	}
+
+	return response, nil
+})
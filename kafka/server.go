@@ -2,68 +2,171 @@ package kafka
 
 import (
 	"context"
+	"crypto/tls"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/xuanswe/mini-kafka/internal/encoders"
 	"github.com/xuanswe/mini-kafka/internal/models"
 	"github.com/xuanswe/mini-kafka/internal/support"
 	"io"
+	"math/rand"
 	"net"
 	"sync"
+	"syscall"
 	"time"
 )
 
+const (
+	// minAcceptBackoff is the initial sleep after a temporary Accept error.
+	minAcceptBackoff = 5 * time.Millisecond
+	// maxAcceptBackoff caps how long Serve will sleep between Accept retries.
+	maxAcceptBackoff = 1 * time.Second
+)
+
+// isTemporary reports whether err is a transient Accept error (e.g. the
+// process hit its file descriptor limit) that's worth retrying after a
+// backoff, as opposed to one that should terminate Serve.
+func isTemporary(err error) bool {
+	if errors.Is(err, syscall.ECONNABORTED) {
+		return true
+	}
+	var te interface{ Temporary() bool }
+	return errors.As(err, &te) && te.Temporary()
+}
+
 type ServerInterface interface {
 	Start() error
+	Serve(l net.Listener) error
 	ForceShutdown() error
-	Shutdown() error
+	Shutdown(ctx context.Context) error
 	Config() ServerConfig
 }
 
-type Server struct {
-	config   ServerConfig
-	listener net.Listener
-	conns    map[net.Conn]struct{}
+// connState describes where a tracked connection is in its lifecycle,
+// mirroring the state machine net/http.Server uses to decide which
+// connections can be closed immediately during a graceful shutdown and
+// which must be left to drain.
+type connState int
+
+const (
+	// StateNew is a connection that has just been accepted.
+	StateNew connState = iota
+	// StateActive is a connection with a request currently being handled.
+	StateActive
+	// StateIdle is a connection between requests, waiting to read the next one.
+	StateIdle
+	// StateClosed is a connection that has been closed.
+	StateClosed
+)
+
+// conn tracks the lifecycle state of a single accepted connection so that
+// Shutdown can close idle connections immediately while letting active ones
+// drain. The state reflects whether a request on this connection is
+// currently being handled (see beginRequest/endRequest), not merely whether
+// a request has been read off the wire.
+type conn struct {
+	net.Conn
+
+	mu       sync.Mutex
+	state    connState
+	inFlight int
 }
 
-type ServerConfig struct {
-	Host            string
-	Port            string
-	ConnIdleTimeout time.Duration
+func (c *conn) setState(state connState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state = state
 }
 
-// onceCloseListener wraps a net.Listener, protecting it from
-// multiple Close calls.
-type onceCloseListener struct {
-	net.Listener
-	once     sync.Once
-	closeErr error
+func (c *conn) getState() connState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
 }
 
-func (oc *onceCloseListener) Close() error {
-	oc.once.Do(func() {
-		oc.closeErr = oc.Listener.Close()
-	})
-	return oc.closeErr
+// beginRequest marks the connection active for the duration of handling one
+// request. Call endRequest when that request's handling (and response
+// encoding) is done.
+func (c *conn) beginRequest() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlight++
+	c.state = StateActive
 }
 
-// onceCloseListener wraps a net.Listener, protecting it from
-// multiple Close calls.
-type onceCloseConn struct {
-	net.Conn
-	once     sync.Once
-	closeErr error
+func (c *conn) endRequest() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlight--
+	if c.inFlight == 0 {
+		c.state = StateIdle
+	}
 }
 
-func (oc *onceCloseConn) Close() error {
-	oc.once.Do(func() {
-		log.Debug().Msgf("Closing connection %v", oc.Conn.RemoteAddr())
-		oc.closeErr = oc.Conn.Close()
-		if oc.closeErr == nil {
-			log.Debug().Msgf("Closed connection %v", oc.Conn.RemoteAddr())
-		}
-	})
-	return oc.closeErr
+// Close protects the underlying net.Conn from multiple Close calls and
+// records the connection as closed.
+func (c *conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == StateClosed {
+		return nil
+	}
+	c.state = StateClosed
+	log.Debug().Msgf("Closing connection %v", c.Conn.RemoteAddr())
+	err := c.Conn.Close()
+	if err == nil {
+		log.Debug().Msgf("Closed connection %v", c.Conn.RemoteAddr())
+	}
+	return err
+}
+
+type Server struct {
+	config ServerConfig
+	handle Next
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*conn]struct{}
+	wg        sync.WaitGroup
+
+	shuttingDown bool
+	done         chan struct{}
+}
+
+type ServerConfig struct {
+	Host            string
+	Port            string
+	ConnIdleTimeout time.Duration
+
+	// TLSConfig, if non-nil, makes Start listen with TLS (and, via
+	// TLSConfig.ClientAuth, optionally require client certificates for
+	// mTLS). Build one with [LoadTLSConfig].
+	TLSConfig *tls.Config
+
+	// Handler processes decoded requests. If nil, the server falls back to
+	// its built-in per-API handlers (see defaultHandler).
+	Handler Handler
+	// Interceptors wrap every call to Handler, outermost first. Use these
+	// for cross-cutting concerns like logging, panic recovery, timeouts, or
+	// metrics instead of modifying Handler itself. RecoveryInterceptor runs
+	// ahead of these regardless, so a panic here still can't crash the
+	// server.
+	Interceptors []Interceptor
+
+	// MaxRequestSize bounds the size of a single request frame (the int32
+	// length prefix read off the wire); a frame claiming to be larger is
+	// rejected, and its connection closed, before the frame body is read.
+	// Defaults to encoders.DefaultMaxRequestSize if <= 0.
+	MaxRequestSize int32
+
+	// MaxInFlightRequestsPerConnection bounds how many requests on a single
+	// connection may be decoded and handled concurrently before their
+	// responses are written; once the limit is reached, reading further
+	// requests off the connection blocks. Responses are always written in
+	// the order their requests arrived, regardless of how long any
+	// individual request takes to process. Defaults to
+	// DefaultMaxInFlightRequestsPerConnection if <= 0.
+	MaxInFlightRequestsPerConnection int
 }
 
 func NewServer(config ServerConfig) (ServerInterface, error) {
@@ -71,9 +174,22 @@ func NewServer(config ServerConfig) (ServerInterface, error) {
 		return nil, errors.New("ConnIdleTimeout must be greater than 0")
 	}
 
+	handler := config.Handler
+	if handler == nil {
+		handler = defaultHandler
+	}
+
+	// RecoveryInterceptor is always installed, ahead of any user-supplied
+	// interceptors, so a panic anywhere in the chain can't take down the
+	// whole process via an unrecovered goroutine in pipelineRequests.
+	interceptors := append([]Interceptor{RecoveryInterceptor}, config.Interceptors...)
+
 	return &Server{
-		config: config,
-		conns:  make(map[net.Conn]struct{}),
+		config:    config,
+		handle:    chainInterceptors(interceptors, handler),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*conn]struct{}),
+		done:      make(chan struct{}),
 	}, nil
 }
 
@@ -81,54 +197,144 @@ func (s *Server) Config() ServerConfig {
 	return s.config
 }
 
+// isShuttingDown reports whether Shutdown or ForceShutdown has been called.
+func (s *Server) isShuttingDown() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) closeDoneLocked() {
+	if !s.shuttingDown {
+		s.shuttingDown = true
+		close(s.done)
+	}
+}
+
 // ForceShutdown immediately closes all active net.Listeners, connections,
-// and other resources.
+// and other resources, without waiting for in-flight requests to finish.
 // For a graceful shutdown, use [Server.Shutdown].
 func (s *Server) ForceShutdown() error {
 	log.Info().Msg("Force shutting down kafka server")
-	if err := s.listener.Close(); err != nil {
-		return err
+
+	s.mu.Lock()
+	s.closeDoneLocked()
+
+	var err error
+	for l := range s.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
 	}
 
-	for conn := range s.conns {
-		if err := conn.Close(); err != nil {
-			log.Error().Err(err).Msgf("Error closing connection %v", conn.RemoteAddr())
+	for c := range s.conns {
+		if cerr := c.Close(); cerr != nil {
+			log.Error().Err(cerr).Msgf("Error closing connection %v", c.Conn.RemoteAddr())
 		}
-		delete(s.conns, conn)
 	}
+	s.mu.Unlock()
 
-	return nil
+	return err
 }
 
-// Shutdown gracefully shuts down the server without interrupting any active
-// connections and resources.
-func (s *Server) Shutdown() error {
-	// TODO: close gracefully
-	//log.Info().Msg("Gracefully shutting down kafka server")
-	return s.ForceShutdown()
+// Shutdown gracefully shuts down the server: it closes all listeners so no
+// new connections are accepted, closes any connection that is currently
+// idle, and then waits for connections with an in-flight request to finish
+// and flush their response before closing them too. If ctx is cancelled
+// before all connections have drained, Shutdown falls back to ForceShutdown.
+func (s *Server) Shutdown(ctx context.Context) error {
+	log.Info().Msg("Gracefully shutting down kafka server")
+
+	s.mu.Lock()
+	s.closeDoneLocked()
+
+	var err error
+	for l := range s.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	for c := range s.conns {
+		if c.getState() != StateActive {
+			if cerr := c.Close(); cerr != nil {
+				log.Error().Err(cerr).Msgf("Error closing connection %v", c.Conn.RemoteAddr())
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return err
+	case <-ctx.Done():
+		log.Warn().Msg("Shutdown deadline exceeded, forcing remaining connections closed")
+		if ferr := s.ForceShutdown(); ferr != nil && err == nil {
+			err = ferr
+		}
+		if err == nil {
+			err = ctx.Err()
+		}
+		return err
+	}
 }
 
-// Start starts the server and block
+// Start binds to the configured host and port and starts serving. It blocks
+// until the server is shut down or Accept returns a non-recoverable error.
 func (s *Server) Start() error {
-	listener, err := net.Listen("tcp", net.JoinHostPort(s.config.Host, s.config.Port))
+	addr := net.JoinHostPort(s.config.Host, s.config.Port)
+
+	var listener net.Listener
+	var err error
+	if s.config.TLSConfig != nil {
+		listener, err = tls.Listen("tcp", addr, s.config.TLSConfig)
+	} else {
+		listener, err = net.Listen("tcp", addr)
+	}
 	if err != nil {
 		log.Error().Err(err).Msgf("Failed to bind to %s:%s", s.config.Host, s.config.Port)
 		return err
 	}
-	s.listener = &onceCloseListener{Listener: listener}
-	defer func(l net.Listener) {
-		if err := l.Close(); err != nil {
-			log.Error().Err(err).Msg("Error closing listener")
-		}
-	}(s.listener)
+
+	return s.Serve(listener)
+}
+
+// Serve accepts connections on l and handles them until l returns an error
+// or the server is shut down. Serve can be called multiple times with
+// different listeners to have the server listen on more than one address,
+// e.g. a TCP socket and a Unix socket.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.isShuttingDown() {
+		s.mu.Unlock()
+		return errors.New("server is shutting down")
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
 
 	ctx, cancelCtx := context.WithCancel(context.Background())
 	defer cancelCtx()
 
-	log.Info().Msg("kafka server started")
+	log.Info().Msgf("kafka server listening on %v", l.Addr())
 
+	var backoff time.Duration
 	for {
-		conn, err := s.listener.Accept()
+		rawConn, err := l.Accept()
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
 				log.Debug().Msg("Listener is closed")
@@ -136,62 +342,139 @@ func (s *Server) Start() error {
 				return nil
 			}
 
+			if isTemporary(err) {
+				if backoff == 0 {
+					backoff = minAcceptBackoff
+				} else {
+					backoff *= 2
+				}
+				if backoff > maxAcceptBackoff {
+					backoff = maxAcceptBackoff
+				}
+
+				log.Error().Err(err).Msgf("Error accepting connection, retrying in %v", backoff)
+				time.Sleep(time.Duration(rand.Int63n(int64(backoff))))
+				continue
+			}
+
 			log.Error().Err(err).Msg("Error accepting connection")
-			continue
+			return err
 		}
-		conn = &onceCloseConn{Conn: conn}
-		s.conns[conn] = struct{}{}
+		backoff = 0
 
+		c := &conn{Conn: rawConn, state: StateNew}
+
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
 		go func() {
-			err := handleConnection(ctx, conn, s.config)
-			if err != nil {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, c)
+				s.mu.Unlock()
+			}()
+
+			// *tls.Conn performs its handshake lazily on first Read, so force
+			// it here. Otherwise the TLSState snapshotted for the first
+			// request (see createRequestChan) would reflect a connection
+			// whose handshake hasn't completed yet.
+			if tc, ok := c.Conn.(*tls.Conn); ok {
+				if err := tc.HandshakeContext(ctx); err != nil {
+					log.Error().Err(err).Msgf("TLS handshake failed for %v", c.Conn.RemoteAddr())
+					return
+				}
+			}
+
+			// handleConnection gets a context of its own, not the accept
+			// loop's ctx: that one is cancelled the instant Shutdown closes
+			// this listener, which would tear down every open connection
+			// (and any in-flight request) immediately instead of letting
+			// Shutdown's idle/active drain logic decide. A connection's
+			// lifetime ends only via conn.Close(): on EOF, on
+			// Shutdown/ForceShutdown closing it directly, or once its
+			// requests finish draining. s.done is passed separately so the
+			// connection's read loop can stop waiting for a new request as
+			// soon as shutdown begins, rather than sitting idle until
+			// ConnIdleTimeout elapses.
+			if err := handleConnection(context.Background(), c, s.config, s.handle, s.done); err != nil {
 				log.Error().Err(err).Msg("Error handling connection")
 			}
-			delete(s.conns, conn)
 		}()
 	}
 }
 
-func handleConnection(ctx context.Context, conn net.Conn, config ServerConfig) error {
-	// TODO: Close in-progress connections gracefully?
-	defer func(conn net.Conn) {
-		err := conn.Close()
-		if err != nil {
-			log.Error().Err(err).Msgf("Error closing connection %v", conn.RemoteAddr())
+func handleConnection(ctx context.Context, c *conn, config ServerConfig, handle Next, shutdown <-chan struct{}) error {
+	defer func() {
+		if err := c.Close(); err != nil {
+			log.Error().Err(err).Msgf("Error closing connection %v", c.Conn.RemoteAddr())
 		}
-	}(conn)
+	}()
 
 	connCtx, cancelCtx := context.WithCancelCause(ctx)
 	defer cancelCtx(nil)
 
-	requestChan, requestErrChan := createRequestChan(connCtx, conn, config)
-	responseChan := createResponseChan(connCtx, requestChan)
+	maxInFlight := config.MaxInFlightRequestsPerConnection
+	if maxInFlight <= 0 {
+		maxInFlight = DefaultMaxInFlightRequestsPerConnection
+	}
+
+	requestChan, requestErrChan := createRequestChan(connCtx, c, config, shutdown)
+	responseChan := pipelineRequests(connCtx, c, requestChan, handle, maxInFlight)
 
 	go func() {
-		err := sendResponses(connCtx, conn, responseChan)
+		err := sendResponses(connCtx, c, responseChan)
 		cancelCtx(err)
 	}()
 
-	select {
-	case <-connCtx.Done():
-		err := context.Cause(connCtx)
-		if err != nil {
+	// requestErrChan is closed (not just left unsent) once the read loop
+	// stops, whether that's because of a real read error or simply because
+	// there are no more requests to read (EOF, idle timeout, shutdown). A
+	// closed channel is always ready to receive its zero value, so once
+	// that happens this select must stop selecting it -- otherwise it
+	// would report a nil "error" and return the instant reading stops,
+	// even though requestChan's closure is still draining in-flight
+	// requests through the pipeline towards connCtx.Done(). Nil-ing the
+	// channel makes this select block on connCtx.Done() alone from then on.
+	for {
+		select {
+		case <-connCtx.Done():
+			err := context.Cause(connCtx)
+			if err != nil {
+				return err
+			}
+			return connCtx.Err()
+		case err, ok := <-requestErrChan:
+			if !ok {
+				requestErrChan = nil
+				continue
+			}
+			cancelCtx(err)
 			return err
 		}
-		return connCtx.Err()
-	case err := <-requestErrChan:
-		cancelCtx(err)
-		return err
 	}
 }
 
-func createRequestChan(ctx context.Context, conn net.Conn, config ServerConfig) (<-chan *models.Request, <-chan error) {
-	reader := support.EnsureBufferedReader(conn)
+// createRequestChan reads successive requests off c and decodes them onto
+// the returned channel until ctx is done, shutdown is closed, or the
+// connection is closed or errors. shutdown lets a connection between
+// requests stop waiting for the next one as soon as the server starts
+// shutting down, instead of sitting idle until ConnIdleTimeout elapses.
+func createRequestChan(ctx context.Context, c *conn, config ServerConfig, shutdown <-chan struct{}) (<-chan *models.Request, <-chan error) {
+	reader := support.EnsureBufferedReader(c)
 	requestChan := make(chan *models.Request)
 	errChan := make(chan error)
 
-	remoteAddr := conn.RemoteAddr().String()
-	readRequestChan := createReadRequestChan(reader)
+	maxRequestSize := config.MaxRequestSize
+	if maxRequestSize <= 0 {
+		maxRequestSize = encoders.DefaultMaxRequestSize
+	}
+
+	remoteAddr := c.Conn.RemoteAddr().String()
+	tlsState := tlsConnectionState(c.Conn)
+	readRequestChan := createReadRequestChan(reader, maxRequestSize)
 
 	go func() {
 		defer close(requestChan)
@@ -204,6 +487,8 @@ func createRequestChan(ctx context.Context, conn net.Conn, config ServerConfig)
 			select {
 			case <-requestCtx.Done():
 				return
+			case <-shutdown:
+				return
 			case result := <-readRequestChan:
 				err := result.err
 				if err != nil {
@@ -220,6 +505,7 @@ func createRequestChan(ctx context.Context, conn net.Conn, config ServerConfig)
 					return
 				}
 				result.request.RemoteAddr = remoteAddr
+				result.request.TLSState = tlsState
 				requestChan <- result.request
 			}
 		}
@@ -229,7 +515,7 @@ func createRequestChan(ctx context.Context, conn net.Conn, config ServerConfig)
 }
 
 // read requests from reader until the reader is closed or throws an error
-func createReadRequestChan(reader io.Reader) <-chan struct {
+func createReadRequestChan(reader io.Reader, maxRequestSize int32) <-chan struct {
 	request *models.Request
 	err     error
 } {
@@ -247,7 +533,7 @@ func createReadRequestChan(reader io.Reader) <-chan struct {
 			// Closing reader is managed outside this goroutine.
 			var request *models.Request
 			var err error
-			request, err = encoders.ReadRequest(reader)
+			request, err = encoders.ReadRequest(reader, maxRequestSize)
 
 			readRequestChan <- struct {
 				request *models.Request
@@ -263,37 +549,6 @@ func createReadRequestChan(reader io.Reader) <-chan struct {
 	return readRequestChan
 }
 
-func createResponseChan(ctx context.Context, requestChan <-chan *models.Request) <-chan []byte {
-	responseChan := make(chan []byte)
-
-	go func() {
-		defer close(responseChan)
-
-		var wg sync.WaitGroup
-		for request := range requestChan {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				wg.Add(1)
-
-				go func(req *models.Request) {
-					defer wg.Done()
-
-					if response, err := handleRequest(req); err != nil {
-						log.Error().Err(err).Msg("Error processing request")
-					} else {
-						responseChan <- response
-					}
-				}(request)
-			}
-		}
-		wg.Wait()
-	}()
-
-	return responseChan
-}
-
 func sendResponses(ctx context.Context, w io.Writer, responseChan <-chan []byte) error {
 	bf := support.EnsureBufferedWriter(w)
 	for bytes := range responseChan {
@@ -0,0 +1,227 @@
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xuanswe/mini-kafka/internal/models"
+)
+
+func newTestServer(t *testing.T, handler Handler) ServerInterface {
+	t.Helper()
+
+	server, err := NewServer(ServerConfig{
+		Host:            "127.0.0.1",
+		Port:            "0",
+		ConnIdleTimeout: time.Second,
+		Handler:         handler,
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	return server
+}
+
+func newTestListener(t *testing.T) net.Listener {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	return listener
+}
+
+// buildApiVersionsRequestFrame encodes a minimal ApiVersions (key 18, version
+// 0) request frame, length-prefixed and ready to write to a connection.
+func buildApiVersionsRequestFrame(correlationId int32) []byte {
+	header := make([]byte, 0, 10)
+	header = binary.BigEndian.AppendUint16(header, 18) // api_key
+	header = binary.BigEndian.AppendUint16(header, 0)  // api_version
+	header = binary.BigEndian.AppendUint32(header, uint32(correlationId))
+	header = binary.BigEndian.AppendUint16(header, 0xFFFF) // client_id = null (-1)
+	header = append(header, 0)                             // tagged fields = 0
+
+	frame := make([]byte, 0, 4+len(header))
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(header)))
+	frame = append(frame, header...)
+	return frame
+}
+
+// sendRequestAndReadResponseCorrelationId dials addr, writes a single
+// ApiVersions request with the given correlation_id, and returns the
+// correlation_id echoed back in the response.
+func sendRequestAndReadResponseCorrelationId(t *testing.T, addr string, correlationId int32) int32 {
+	t.Helper()
+
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(buildApiVersionsRequestFrame(correlationId)); err != nil {
+		t.Fatalf("write request: %v", err)
+	}
+
+	var size int32
+	if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+		t.Fatalf("read response size: %v", err)
+	}
+	body := make([]byte, size)
+	if _, err := readFull(conn, body); err != nil {
+		t.Fatalf("read response body: %v", err)
+	}
+
+	return int32(binary.BigEndian.Uint32(body[:4]))
+}
+
+func readFull(conn net.Conn, buf []byte) (int, error) {
+	total := 0
+	for total < len(buf) {
+		n, err := conn.Read(buf[total:])
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func TestServer_Shutdown_WaitsForInFlightRequest(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	handler := HandlerFunc(func(ctx context.Context, req *models.Request) (*models.Response, error) {
+		close(started)
+		<-release
+		return &models.Response{CorrelationId: req.CorrelationId}, nil
+	})
+
+	server := newTestServer(t, handler)
+	listener := newTestListener(t)
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.Serve(listener) }()
+
+	conn, err := net.DialTimeout("tcp", listener.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(buildApiVersionsRequestFrame(1)); err != nil {
+		t.Fatalf("write request: %v", err)
+	}
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("handler never started")
+	}
+
+	shutdownDone := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		shutdownDone <- server.Shutdown(ctx)
+	}()
+
+	select {
+	case <-shutdownDone:
+		t.Fatal("Shutdown returned before the in-flight request finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+
+	select {
+	case err := <-shutdownDone:
+		if err != nil {
+			t.Fatalf("Shutdown: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown never returned after the in-flight request finished")
+	}
+
+	if err := <-serveErr; err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+}
+
+func TestServer_Shutdown_ForcesCloseAfterDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	handler := HandlerFunc(func(ctx context.Context, req *models.Request) (*models.Response, error) {
+		<-release
+		return &models.Response{CorrelationId: req.CorrelationId}, nil
+	})
+
+	server := newTestServer(t, handler)
+	listener := newTestListener(t)
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.Serve(listener) }()
+
+	conn, err := net.DialTimeout("tcp", listener.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(buildApiVersionsRequestFrame(1)); err != nil {
+		t.Fatalf("write request: %v", err)
+	}
+
+	// Give the handler a moment to start before the deadline elapses.
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err == nil {
+		t.Fatal("expected Shutdown to report an error after forcing the connection closed")
+	}
+
+	<-serveErr
+}
+
+func TestServer_Serve_MultipleListeners(t *testing.T) {
+	handler := HandlerFunc(func(ctx context.Context, req *models.Request) (*models.Response, error) {
+		return &models.Response{CorrelationId: req.CorrelationId}, nil
+	})
+
+	server := newTestServer(t, handler)
+	listenerA := newTestListener(t)
+	listenerB := newTestListener(t)
+
+	errA := make(chan error, 1)
+	errB := make(chan error, 1)
+	go func() { errA <- server.Serve(listenerA) }()
+	go func() { errB <- server.Serve(listenerB) }()
+
+	for i, addr := range []string{listenerA.Addr().String(), listenerB.Addr().String()} {
+		correlationId := int32(i + 1)
+		got := sendRequestAndReadResponseCorrelationId(t, addr, correlationId)
+		if got != correlationId {
+			t.Fatalf("listener %d: got correlation_id %d, want %d", i, got, correlationId)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	if err := <-errA; err != nil {
+		t.Fatalf("Serve (listener A): %v", err)
+	}
+	if err := <-errB; err != nil {
+		t.Fatalf("Serve (listener B): %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// LoadTLSConfig builds a *tls.Config for ServerConfig.TLSConfig from a
+// server certificate/key pair. If caFile is non-empty, it's used to verify
+// client certificates under clientAuth (mTLS); otherwise clientAuth governs
+// whether/how client certificates are requested against the system roots.
+func LoadTLSConfig(certFile, keyFile, caFile string, clientAuth tls.ClientAuthType) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading server certificate")
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   clientAuth,
+	}
+
+	if caFile != "" {
+		caCert, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading client CA file")
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("no certificates found in client CA file")
+		}
+		tlsConfig.ClientCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// tlsConnectionState returns the TLS connection state for c, or nil if c
+// isn't a TLS connection.
+func tlsConnectionState(c net.Conn) *tls.ConnectionState {
+	tc, ok := c.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	state := tc.ConnectionState()
+	return &state
+}